@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// readID3v2Tags reads the common text frames (TPE1/TALB/TIT2/TRCK) out of an
+// ID3v2.3 or ID3v2.4 header, which is all Import needs to lay out an MP3.
+// It deliberately ignores ID3v1 trailers and non-text frames.
+func readID3v2Tags(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return nil, fmt.Errorf("%s: no ID3v2 header found", file)
+	}
+
+	size := synchsafeToInt(header[6:10])
+	body := make([]byte, size)
+	if _, err := io.ReadFull(f, body); err != nil {
+		return nil, err
+	}
+
+	frameIDs := map[string]string{
+		"TPE1": "Artist",
+		"TALB": "Album",
+		"TIT2": "Title",
+		"TRCK": "Track",
+	}
+
+	tags := make(map[string]string)
+
+	pos := 0
+	for pos+10 <= len(body) {
+		id := string(body[pos : pos+4])
+		if id == "\x00\x00\x00\x00" {
+			break
+		}
+		frameSize := int(binary.BigEndian.Uint32(body[pos+4 : pos+8]))
+		if header[3] >= 4 { // ID3v2.4 frame sizes are synchsafe; v2.3's are plain
+			frameSize = synchsafeToInt(body[pos+4 : pos+8])
+		}
+		pos += 10
+		if frameSize <= 0 || pos+frameSize > len(body) {
+			break
+		}
+		if tagName, known := frameIDs[id]; known {
+			tags[tagName] = decodeID3Text(body[pos : pos+frameSize])
+		}
+		pos += frameSize
+	}
+
+	tags["File Type"] = "MP3"
+	return tags, nil
+}
+
+// synchsafeToInt decodes a 4-byte synchsafe integer (the high bit of every
+// byte is unused) as used by the ID3v2 header size field.
+func synchsafeToInt(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+// decodeID3Text strips the encoding byte and trailing NULs from an ID3v2
+// text frame, handling the ISO-8859-1 and UTF-16 encodings seen in practice.
+func decodeID3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	encoding, data := data[0], data[1:]
+	switch encoding {
+	case 1, 2: // UTF-16 with or without BOM
+		return strings.TrimRight(decodeUTF16(data), "\x00")
+	default: // ISO-8859-1 / UTF-8
+		return strings.Trim(string(data), "\x00 ")
+	}
+}
+
+func decodeUTF16(data []byte) string {
+	if len(data) < 2 {
+		return ""
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if data[0] == 0xff && data[1] == 0xfe {
+		order = binary.LittleEndian
+		data = data[2:]
+	} else if data[0] == 0xfe && data[1] == 0xff {
+		data = data[2:]
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:i+2]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// readFLACTags reads the VORBIS_COMMENT metadata block out of a FLAC stream
+// and maps it onto the same tag names readID3v2Tags produces.
+func readFLACTags(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, fmt.Errorf("%s: not a FLAC stream", file)
+	}
+
+	for {
+		blockHeader := make([]byte, 4)
+		if _, err := io.ReadFull(f, blockHeader); err != nil {
+			return nil, err
+		}
+		last := blockHeader[0]&0x80 != 0
+		blockType := blockHeader[0] & 0x7f
+		blockLen := int(blockHeader[1])<<16 | int(blockHeader[2])<<8 | int(blockHeader[3])
+
+		block := make([]byte, blockLen)
+		if _, err := io.ReadFull(f, block); err != nil {
+			return nil, err
+		}
+
+		if blockType == 4 { // VORBIS_COMMENT
+			return parseVorbisComment(block), nil
+		}
+
+		if last {
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("%s: no VORBIS_COMMENT block found", file)
+}
+
+// readWAVTags reads the RIFF "LIST"/"INFO" chunk out of a WAV file, mapping
+// its INAM/IART/IPRD/ITRK sub-chunks onto the same tag names readID3v2Tags
+// and readFLACTags produce. A WAV with no INFO chunk (most of them) yields
+// just "File Type"; Import falls back to "Unknown Artist"/etc. for whatever's
+// missing.
+func readWAVTags(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%s: not a RIFF/WAVE stream", file)
+	}
+
+	tags := make(map[string]string)
+
+	keyMap := map[string]string{
+		"INAM": "Title",
+		"IART": "Artist",
+		"IPRD": "Album",
+		"ITRK": "Track",
+	}
+
+	for {
+		chunkHeader := make([]byte, 8)
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			break
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkLen := int(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		padded := chunkLen + chunkLen%2
+
+		if chunkID != "LIST" {
+			if _, err := f.Seek(int64(padded), io.SeekCurrent); err != nil {
+				break
+			}
+			continue
+		}
+
+		body := make([]byte, padded)
+		if _, err := io.ReadFull(f, body); err != nil {
+			break
+		}
+		body = body[:chunkLen]
+
+		if len(body) < 4 || string(body[0:4]) != "INFO" {
+			continue
+		}
+
+		pos := 4
+		for pos+8 <= len(body) {
+			subID := string(body[pos : pos+4])
+			subLen := int(binary.LittleEndian.Uint32(body[pos+4 : pos+8]))
+			pos += 8
+			if subLen < 0 || pos+subLen > len(body) {
+				break
+			}
+			if tagName, known := keyMap[subID]; known {
+				tags[tagName] = strings.TrimRight(string(body[pos:pos+subLen]), "\x00")
+			}
+			pos += subLen + subLen%2
+		}
+	}
+
+	tags["File Type"] = "WAV"
+	return tags, nil
+}
+
+func parseVorbisComment(block []byte) map[string]string {
+	tags := make(map[string]string)
+	if len(block) < 4 {
+		return tags
+	}
+
+	vendorLen := int(binary.LittleEndian.Uint32(block[0:4]))
+	pos := 4 + vendorLen
+	if pos+4 > len(block) {
+		return tags
+	}
+
+	commentCount := int(binary.LittleEndian.Uint32(block[pos : pos+4]))
+	pos += 4
+
+	keyMap := map[string]string{
+		"ARTIST":      "Artist",
+		"ALBUM":       "Album",
+		"TITLE":       "Title",
+		"TRACKNUMBER": "Track",
+	}
+
+	for i := 0; i < commentCount && pos+4 <= len(block); i++ {
+		length := int(binary.LittleEndian.Uint32(block[pos : pos+4]))
+		pos += 4
+		if pos+length > len(block) {
+			break
+		}
+		comment := string(block[pos : pos+length])
+		pos += length
+
+		parts := strings.SplitN(comment, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if tagName, known := keyMap[strings.ToUpper(parts[0])]; known {
+			tags[tagName] = parts[1]
+		}
+	}
+
+	tags["File Type"] = "FLAC"
+	return tags
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gosexy/exif"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// MetadataExtractor reads tag-like metadata out of a media file into the
+// same flat string map shape Import has always worked with (e.g. "Date/Time
+// Original", "Artist"), so swapping extractors never touches call sites.
+type MetadataExtractor interface {
+	// Name identifies the extractor in -extractors chains and log messages.
+	Name() string
+	// Handles reports whether this extractor is meaningful for ext (lowercased, with the leading dot).
+	Handles(ext string) bool
+	// Extract reads tags from file.
+	Extract(file string) (map[string]string, error)
+}
+
+var videoExtensions = map[string]bool{".mp4": true, ".mov": true, ".mkv": true, ".avi": true, ".flv": true}
+
+// libexifExtractor wraps the existing github.com/gosexy/exif bindings.
+type libexifExtractor struct{}
+
+func (libexifExtractor) Name() string { return "libexif" }
+
+func (libexifExtractor) Handles(ext string) bool {
+	switch ext {
+	case ".jpg", ".jpeg", ".tif", ".tiff", ".png":
+		return true
+	}
+	return false
+}
+
+func (libexifExtractor) Extract(file string) (map[string]string, error) {
+	ex := exif.New()
+	if err := ex.Open(file); err != nil {
+		return nil, err
+	}
+	return ex.Tags, nil
+}
+
+// exiftoolExtractor shells out to the exiftool binary and parses its
+// fixed-width column output (tag name in the first 32 columns, value after).
+type exiftoolExtractor struct{}
+
+func (exiftoolExtractor) Name() string { return "exiftool" }
+
+func (exiftoolExtractor) Handles(ext string) bool { return true }
+
+func (exiftoolExtractor) Extract(file string) (map[string]string, error) {
+	cmd := exec.Command("exiftool", file)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string)
+
+	data := strings.Trim(out.String(), " \r\n")
+	lines := strings.Split(data, "\n")
+
+	for _, line := range lines {
+		key := strings.Trim(line[0:32], " ")
+		value := strings.Trim(line[33:], " ")
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// ffprobeExtractor reads container-level tags out of video files, mapping
+// creation_time / com.apple.quicktime.creationdate onto "Media Create Date"
+// so the existing dateTimeFields lookup in Import picks them up unchanged.
+type ffprobeExtractor struct{}
+
+func (ffprobeExtractor) Name() string { return "ffprobe" }
+
+func (ffprobeExtractor) Handles(ext string) bool { return videoExtensions[ext] }
+
+func (ffprobeExtractor) Extract(file string) (map[string]string, error) {
+	cmd := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", file)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	var probe struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+	}
+
+	if err := json.Unmarshal(out.Bytes(), &probe); err != nil {
+		return nil, err
+	}
+
+	tags := probe.Format.Tags
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+
+	creation := pick(tags["creation_time"], tags["com.apple.quicktime.creationdate"])
+	if creation != "" {
+		parsed, err := time.Parse(time.RFC3339, creation)
+		if err != nil {
+			// com.apple.quicktime.creationdate writes its offset without a colon.
+			parsed, err = time.Parse("2006-01-02T15:04:05-0700", creation)
+		}
+		if err == nil {
+			tags["Media Create Date"] = parsed.UTC().Format("2006:01:02 15:04:05")
+		}
+	}
+
+	tags["File Type"] = strings.ToUpper(strings.TrimPrefix(path.Ext(file), "."))
+
+	return tags, nil
+}
+
+// id3Extractor reads audio container tags via the bespoke ID3v2/FLAC readers
+// in audiotags.go, selecting the reader by extension.
+type id3Extractor struct{}
+
+func (id3Extractor) Name() string { return "id3" }
+
+func (id3Extractor) Handles(ext string) bool {
+	switch ext {
+	case ".mp3", ".flac", ".wav":
+		return true
+	}
+	return false
+}
+
+func (id3Extractor) Extract(file string) (map[string]string, error) {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".flac":
+		return readFLACTags(file)
+	case ".wav":
+		return readWAVTags(file)
+	default:
+		return readID3v2Tags(file)
+	}
+}
+
+var extractorRegistry = map[string]MetadataExtractor{
+	"libexif":  libexifExtractor{},
+	"exiftool": exiftoolExtractor{},
+	"ffprobe":  ffprobeExtractor{},
+	"id3":      id3Extractor{},
+}
+
+// resolveExtractorChain turns -extractors (or, absent that, the legacy
+// -exiftool/-try-exiftool flags) into an ordered list of extractors to try.
+func resolveExtractorChain() []MetadataExtractor {
+	var names []string
+
+	if *flagExtractors != "" {
+		names = strings.Split(*flagExtractors, ",")
+	} else {
+		if *flagExifTool == true {
+			names = []string{"exiftool"}
+		} else {
+			names = []string{"libexif"}
+		}
+		if *flagTryExifTool == true {
+			names = append(names, "exiftool")
+		}
+		names = append(names, "ffprobe", "id3")
+	}
+
+	chain := make([]MetadataExtractor, 0, len(names))
+	for _, name := range names {
+		if extractor, ok := extractorRegistry[strings.TrimSpace(name)]; ok {
+			chain = append(chain, extractor)
+		}
+	}
+	return chain
+}
+
+// getMetadata tries each extractor in the resolved chain that claims the
+// file's extension, in order, returning the first successful result.
+func getMetadata(file string) (map[string]string, error) {
+	ext := strings.ToLower(path.Ext(file))
+
+	var lastErr error
+	for _, extractor := range resolveExtractorChain() {
+		if !extractor.Handles(ext) {
+			continue
+		}
+		tags, err := extractor.Extract(file)
+		if err == nil {
+			return tags, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no metadata extractor could handle %s", file)
+	}
+	return nil, lastErr
+}
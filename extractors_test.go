@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// withExtractorFlags sets the flags resolveExtractorChain reads and restores
+// their previous values when the test finishes, since they're package
+// globals shared with the rest of the suite.
+func withExtractorFlags(t *testing.T, extractors string, exifTool bool, tryExifTool bool) {
+	t.Helper()
+
+	prevExtractors, prevExifTool, prevTryExifTool := *flagExtractors, *flagExifTool, *flagTryExifTool
+	*flagExtractors, *flagExifTool, *flagTryExifTool = extractors, exifTool, tryExifTool
+
+	t.Cleanup(func() {
+		*flagExtractors, *flagExifTool, *flagTryExifTool = prevExtractors, prevExifTool, prevTryExifTool
+	})
+}
+
+func chainNames(chain []MetadataExtractor) []string {
+	names := make([]string, len(chain))
+	for i, e := range chain {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+func TestResolveExtractorChainDefault(t *testing.T) {
+	withExtractorFlags(t, "", false, false)
+
+	got := chainNames(resolveExtractorChain())
+	want := []string{"libexif", "ffprobe", "id3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveExtractorChain() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveExtractorChainExifTool(t *testing.T) {
+	withExtractorFlags(t, "", true, false)
+
+	got := chainNames(resolveExtractorChain())
+	want := []string{"exiftool", "ffprobe", "id3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveExtractorChain() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveExtractorChainTryExifToolFallback(t *testing.T) {
+	withExtractorFlags(t, "", false, true)
+
+	got := chainNames(resolveExtractorChain())
+	want := []string{"libexif", "exiftool", "ffprobe", "id3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveExtractorChain() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveExtractorChainExplicit(t *testing.T) {
+	withExtractorFlags(t, "id3, ffprobe", false, false)
+
+	got := chainNames(resolveExtractorChain())
+	want := []string{"id3", "ffprobe"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveExtractorChain() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveExtractorChainExplicitDropsUnknownNames(t *testing.T) {
+	withExtractorFlags(t, "id3,bogus,ffprobe", false, false)
+
+	got := chainNames(resolveExtractorChain())
+	want := []string{"id3", "ffprobe"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("resolveExtractorChain() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// formatContext carries the per-file data a format token can't get from
+// timeTaken alone.
+type formatContext struct {
+	Hash         string // first few hex digits of the file's SHA-1
+	Ext          string // e.g. ".jpg", as returned by path.Ext
+	CameraModel  string // EXIF "Model", if any
+	OriginalBase string // source basename without its extension
+	Locality     string // reverse-geocoded city/town, if GPS tags and a Geocoder are available
+	Country      string // reverse-geocoded country, if GPS tags and a Geocoder are available
+}
+
+const (
+	placeholderHash     = "\x00photopy:hash\x00"
+	placeholderExt      = "\x00photopy:ext\x00"
+	placeholderCamera   = "\x00photopy:camera\x00"
+	placeholderOrig     = "\x00photopy:orig\x00"
+	placeholderLocality = "\x00photopy:locality\x00"
+	placeholderCountry  = "\x00photopy:country\x00"
+)
+
+// strftimeDate maps the date-related tokens onto the Go reference layout
+// (2006-01-02 15:04:05) so timeTaken.Format can expand them directly.
+var strftimeDate = strings.NewReplacer(
+	"%Y", "2006",
+	"%y", "06",
+	"%m", "01",
+	"%B", "January",
+	"%d", "02",
+	"%A", "Monday",
+	"%H", "15",
+	"%M", "04",
+	"%S", "05",
+)
+
+// strftimeFileTokens maps the tokens that depend on the file rather than
+// the time onto placeholders formatPath fills in after Format runs.
+var strftimeFileTokens = strings.NewReplacer(
+	"%h", placeholderHash,
+	"%e", placeholderExt,
+	"%c", placeholderCamera,
+	"%o", placeholderOrig,
+	"%L", placeholderLocality,
+	"%C", placeholderCountry,
+)
+
+// compileFormat translates a -format token string (e.g.
+// "%Y/%m-%B/%d-%A/%H%M%S-%h%e") into a Go time.Format layout once at
+// startup, so Import only has to call timeTaken.Format(layout) per file.
+func compileFormat(format string) string {
+	return strftimeDate.Replace(strftimeFileTokens.Replace(format))
+}
+
+// formatPath expands a layout produced by compileFormat against timeTaken
+// and ctx, then swaps the token string's "/" separators for PS.
+func formatPath(layout string, timeTaken time.Time, ctx formatContext) string {
+	expanded := timeTaken.Format(layout)
+
+	replacer := strings.NewReplacer(
+		placeholderHash, strings.ToUpper(ctx.Hash),
+		placeholderExt, strings.ToLower(ctx.Ext),
+		placeholderCamera, normalize(pick(ctx.CameraModel, "Unknown Camera")),
+		placeholderOrig, ctx.OriginalBase,
+		placeholderLocality, normalize(pick(ctx.Locality, "Unknown Locality")),
+		placeholderCountry, normalize(pick(ctx.Country, "Unknown Country")),
+	)
+	expanded = replacer.Replace(expanded)
+
+	return strings.Replace(expanded, "/", PS, -1)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileFormat(t *testing.T) {
+	layout := compileFormat("%Y/%m-%B/%d-%A/%H%M%S-%h%e")
+
+	want := "2006/01-January/02-Monday/150405-" + placeholderHash + placeholderExt
+	if layout != want {
+		t.Fatalf("compileFormat() = %q, want %q", layout, want)
+	}
+}
+
+func TestFormatPath(t *testing.T) {
+	timeTaken := time.Date(2023, time.July, 14, 9, 5, 3, 0, time.UTC)
+
+	layout := compileFormat("%Y/%m/%d/%H%M%S-%h%e")
+	got := formatPath(layout, timeTaken, formatContext{
+		Hash: "3af9",
+		Ext:  ".JPG",
+	})
+
+	want := strings.Join([]string{"2023", "07", "14", "090503-3AF9.jpg"}, PS)
+	if got != want {
+		t.Fatalf("formatPath() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatPathLocalityFallsBackWhenUnknown(t *testing.T) {
+	timeTaken := time.Date(2023, time.July, 14, 9, 5, 3, 0, time.UTC)
+
+	layout := compileFormat("%Y/%L/%C")
+	got := formatPath(layout, timeTaken, formatContext{})
+
+	want := strings.Join([]string{"2023", "unknown_locality", "unknown_country"}, PS)
+	if got != want {
+		t.Fatalf("formatPath() = %q, want %q", got, want)
+	}
+}
+
+func TestFormatPathUsesGeocodedLocality(t *testing.T) {
+	timeTaken := time.Date(2023, time.July, 14, 9, 5, 3, 0, time.UTC)
+
+	layout := compileFormat("%L/%C")
+	got := formatPath(layout, timeTaken, formatContext{
+		Locality: "San Francisco",
+		Country:  "United States",
+	})
+
+	want := strings.Join([]string{"san_francisco", "united_states"}, PS)
+	if got != want {
+		t.Fatalf("formatPath() = %q, want %q", got, want)
+	}
+}
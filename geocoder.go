@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// activeGeocoder resolves the %L/%C format tokens; when it's nil (the common
+// case, since most imports don't need it) they expand to "unknown_locality"
+// and "unknown_country".
+var activeGeocoder Geocoder
+
+// Geocoder resolves a latitude/longitude pair to the locality and country
+// it falls within.
+type Geocoder interface {
+	Reverse(lat float64, lon float64) (locality string, country string, err error)
+}
+
+var gpsDMSPattern = regexp.MustCompile(`(-?[\d.]+)\s*deg\s*([\d.]+)'\s*([\d.]+)"\s*([NSEW])`)
+
+// parseGPSCoordinate parses either a plain decimal-degree string or
+// exiftool's "D deg M' S\" H" DMS format into a signed decimal degree.
+func parseGPSCoordinate(raw string) (float64, error) {
+	raw = strings.TrimSpace(raw)
+
+	if value, err := strconv.ParseFloat(raw, 64); err == nil {
+		return value, nil
+	}
+
+	m := gpsDMSPattern.FindStringSubmatch(raw)
+	if m == nil {
+		return 0, fmt.Errorf("%s: unrecognized GPS coordinate format", raw)
+	}
+
+	degrees, _ := strconv.ParseFloat(m[1], 64)
+	minutes, _ := strconv.ParseFloat(m[2], 64)
+	seconds, _ := strconv.ParseFloat(m[3], 64)
+
+	value := degrees + minutes/60 + seconds/3600
+	if m[4] == "S" || m[4] == "W" {
+		value = -value
+	}
+	return value, nil
+}
+
+// haversineKM is the great-circle distance between two lat/lon points, in
+// kilometers.
+func haversineKM(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKM = 6371.0
+	toRad := func(d float64) float64 { return d * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(a))
+}
+
+// gazetteerCity is one entry of a cities1000.txt gazetteer.
+type gazetteerCity struct {
+	Name    string
+	Country string
+	Lat     float64
+	Lon     float64
+}
+
+// cityKDNode is a node of a 2-d tree over gazetteer coordinates, letting
+// OfflineGeocoder find the nearest city in O(log n) instead of scanning the
+// whole gazetteer per photo.
+type cityKDNode struct {
+	city        gazetteerCity
+	left, right *cityKDNode
+}
+
+func buildCityKDTree(cities []gazetteerCity, depth int) *cityKDNode {
+	if len(cities) == 0 {
+		return nil
+	}
+
+	axis := depth % 2
+	sort.Slice(cities, func(i, j int) bool {
+		if axis == 0 {
+			return cities[i].Lat < cities[j].Lat
+		}
+		return cities[i].Lon < cities[j].Lon
+	})
+
+	mid := len(cities) / 2
+	node := &cityKDNode{city: cities[mid]}
+	node.left = buildCityKDTree(cities[:mid], depth+1)
+	node.right = buildCityKDTree(cities[mid+1:], depth+1)
+	return node
+}
+
+// nearest returns the node closest to (lat, lon) and its distance in km,
+// pruning the branch that a degree-scale bound rules out.
+func (n *cityKDNode) nearest(lat float64, lon float64, depth int) (*cityKDNode, float64) {
+	if n == nil {
+		return nil, math.Inf(1)
+	}
+
+	best := n
+	bestDist := haversineKM(lat, lon, n.city.Lat, n.city.Lon)
+
+	axis := depth % 2
+	axisDelta := lat - n.city.Lat
+	if axis == 1 {
+		axisDelta = lon - n.city.Lon
+	}
+
+	primary, secondary := n.left, n.right
+	if axisDelta >= 0 {
+		primary, secondary = n.right, n.left
+	}
+
+	if candidate, dist := primary.nearest(lat, lon, depth+1); candidate != nil && dist < bestDist {
+		best, bestDist = candidate, dist
+	}
+
+	// A degree of latitude is roughly 111km, and a degree of longitude that
+	// times cos(lat); only descend into the far branch if it could
+	// plausibly hold something closer than what we already have.
+	axisKM := math.Abs(axisDelta) * 111.0
+	if axis == 1 {
+		axisKM *= math.Cos(lat * math.Pi / 180)
+	}
+	if axisKM < bestDist {
+		if candidate, dist := secondary.nearest(lat, lon, depth+1); candidate != nil && dist < bestDist {
+			best, bestDist = candidate, dist
+		}
+	}
+
+	return best, bestDist
+}
+
+// OfflineGeocoder resolves coordinates against a GeoNames cities1000.txt
+// gazetteer (http://download.geonames.org/export/dump/cities1000.zip), with
+// no network access. cities1000.txt isn't bundled with photopy (it's tens of
+// MB and updates on its own schedule); users download it themselves and
+// point -geocode-cities at it.
+type OfflineGeocoder struct {
+	root *cityKDNode
+}
+
+// geonamesNameCol, geonamesLatCol, geonamesLonCol, and geonamesCountryCol
+// are the 0-indexed tab-separated columns NewOfflineGeocoder reads out of a
+// cities1000.txt row; see GeoNames' own "geoname" table layout:
+// http://download.geonames.org/export/dump/readme.txt
+const (
+	geonamesNameCol    = 1
+	geonamesLatCol     = 4
+	geonamesLonCol     = 5
+	geonamesCountryCol = 8
+)
+
+// NewOfflineGeocoder builds an OfflineGeocoder from a cities1000.txt-format
+// gazetteer.
+func NewOfflineGeocoder(tsvPath string) (*OfflineGeocoder, error) {
+	f, err := os.Open(tsvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cities := make([]gazetteerCity, 0)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) <= geonamesCountryCol {
+			continue
+		}
+		lat, latErr := strconv.ParseFloat(fields[geonamesLatCol], 64)
+		lon, lonErr := strconv.ParseFloat(fields[geonamesLonCol], 64)
+		if latErr != nil || lonErr != nil {
+			continue
+		}
+		cities = append(cities, gazetteerCity{
+			Name:    fields[geonamesNameCol],
+			Country: fields[geonamesCountryCol],
+			Lat:     lat,
+			Lon:     lon,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &OfflineGeocoder{root: buildCityKDTree(cities, 0)}, nil
+}
+
+func (g *OfflineGeocoder) Reverse(lat float64, lon float64) (string, string, error) {
+	if g.root == nil {
+		return "", "", fmt.Errorf("offline geocoder: empty gazetteer")
+	}
+	nearest, _ := g.root.nearest(lat, lon, 0)
+	return nearest.city.Name, nearest.city.Country, nil
+}
+
+// NominatimGeocoder resolves coordinates through the public Nominatim
+// reverse-geocoding API, caching every response on disk keyed by rounded
+// coordinates so repeat lookups (and reruns) are pure-offline after warmup.
+type NominatimGeocoder struct {
+	cacheDir string
+	client   *http.Client
+}
+
+// NewNominatimGeocoder returns a NominatimGeocoder caching responses under cacheDir.
+func NewNominatimGeocoder(cacheDir string) *NominatimGeocoder {
+	return &NominatimGeocoder{cacheDir: cacheDir, client: &http.Client{}}
+}
+
+type nominatimResponse struct {
+	Address struct {
+		City    string `json:"city"`
+		Town    string `json:"town"`
+		Village string `json:"village"`
+		Country string `json:"country"`
+	} `json:"address"`
+}
+
+func (g *NominatimGeocoder) cachePath(lat float64, lon float64) string {
+	name := fmt.Sprintf("%.2f_%.2f.json", lat, lon)
+	name = strings.Replace(name, "-", "n", -1)
+	return strings.Join([]string{g.cacheDir, name}, PS)
+}
+
+// nominatimMinInterval is the minimum gap between outbound requests, per
+// Nominatim's usage policy (https://operations.osmfoundation.org/policies/nominatim/):
+// no more than 1 request/second, regardless of how many pipeline workers
+// are calling Reverse concurrently.
+const nominatimMinInterval = time.Second
+
+// nominatimRateLimiter gates every NominatimGeocoder's outbound requests
+// behind a single shared clock, since -max-procs workers can all be calling
+// Reverse at once.
+var nominatimRateLimiter struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// throttle blocks until at least nominatimMinInterval has passed since the
+// last outbound request made by any NominatimGeocoder in this process.
+func (g *NominatimGeocoder) throttle() {
+	nominatimRateLimiter.mu.Lock()
+	defer nominatimRateLimiter.mu.Unlock()
+
+	if wait := nominatimMinInterval - time.Since(nominatimRateLimiter.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	nominatimRateLimiter.last = time.Now()
+}
+
+func (g *NominatimGeocoder) Reverse(lat float64, lon float64) (string, string, error) {
+	cachePath := g.cachePath(lat, lon)
+
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return parseNominatimResponse(data)
+	}
+
+	g.throttle()
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://nominatim.openstreetmap.org/reverse?format=json&lat=%f&lon=%f", lat, lon), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", "photopy (https://github.com/xiam/photopy)")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := os.MkdirAll(g.cacheDir, os.ModeDir|0750); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(cachePath, body, 0640); err != nil {
+		return "", "", err
+	}
+
+	return parseNominatimResponse(body)
+}
+
+func parseNominatimResponse(data []byte) (string, string, error) {
+	var parsed nominatimResponse
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return "", "", err
+	}
+	return pick(parsed.Address.City, parsed.Address.Town, parsed.Address.Village), parsed.Address.Country, nil
+}
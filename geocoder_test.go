@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHaversineKM(t *testing.T) {
+	// San Francisco to Los Angeles is ~559km by great-circle distance.
+	dist := haversineKM(37.7749, -122.4194, 34.0522, -118.2437)
+	if dist < 550 || dist > 570 {
+		t.Fatalf("haversineKM() = %v, want ~559", dist)
+	}
+
+	if dist := haversineKM(40.0, -73.0, 40.0, -73.0); dist != 0 {
+		t.Fatalf("haversineKM() of identical points = %v, want 0", dist)
+	}
+}
+
+func TestCityKDTreeNearest(t *testing.T) {
+	cities := []gazetteerCity{
+		{Name: "San Francisco", Country: "US", Lat: 37.7749, Lon: -122.4194},
+		{Name: "Oakland", Country: "US", Lat: 37.8044, Lon: -122.2712},
+		{Name: "New York", Country: "US", Lat: 40.7128, Lon: -74.0060},
+		{Name: "Paris", Country: "FR", Lat: 48.8566, Lon: 2.3522},
+	}
+
+	root := buildCityKDTree(cities, 0)
+
+	node, dist := root.nearest(37.78, -122.42, 0)
+	if node.city.Name != "San Francisco" {
+		t.Fatalf("nearest() = %q, want %q", node.city.Name, "San Francisco")
+	}
+	if dist < 0 || dist > 5 {
+		t.Fatalf("nearest() dist = %v, want a handful of km", dist)
+	}
+
+	node, _ = root.nearest(48.85, 2.35, 0)
+	if node.city.Name != "Paris" {
+		t.Fatalf("nearest() = %q, want %q", node.city.Name, "Paris")
+	}
+}
+
+func TestNewOfflineGeocoderParsesGeoNamesColumns(t *testing.T) {
+	// A real cities1000.txt row (truncated to the trailing columns NewOfflineGeocoder
+	// doesn't use): geonameid, name, asciiname, alternatenames, lat, lon,
+	// feature class, feature code, country code, ...
+	line := strings.Join([]string{
+		"5391959", "San Francisco", "San Francisco", "SF",
+		"37.77493", "-122.41942", "P", "PPL", "US",
+		"", "CA", "075", "", "", "873965", "16", "19", "America/Los_Angeles", "2023-05-06",
+	}, "\t")
+
+	tsvPath := filepath.Join(t.TempDir(), "cities1000.txt")
+	if err := os.WriteFile(tsvPath, []byte(line+"\n"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	g, err := NewOfflineGeocoder(tsvPath)
+	if err != nil {
+		t.Fatalf("NewOfflineGeocoder() error = %v", err)
+	}
+
+	locality, country, err := g.Reverse(37.7749, -122.4194)
+	if err != nil {
+		t.Fatalf("Reverse() error = %v", err)
+	}
+	if locality != "San Francisco" {
+		t.Fatalf("Reverse() locality = %q, want %q", locality, "San Francisco")
+	}
+	if country != "US" {
+		t.Fatalf("Reverse() country = %q, want %q", country, "US")
+	}
+}
+
+func TestCityKDTreeNearestEmpty(t *testing.T) {
+	var root *cityKDNode
+	node, dist := root.nearest(0, 0, 0)
+	if node != nil {
+		t.Fatalf("nearest() on empty tree = %v, want nil", node)
+	}
+	if !math.IsInf(dist, 1) {
+		t.Fatalf("nearest() dist on empty tree = %v, want +Inf", dist)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/gosexy/checksum"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// checksumFile is a small wrapper so layout.go doesn't need to repeat the
+// crypto.SHA1 selection at every call site.
+func checksumFile(name string) string {
+	return checksum.File(name, crypto.SHA1)
+}
+
+// casHexDigits enumerates the two-hex-digit shard names used by the
+// content-addressed layout (00..ff).
+const casHexDigits = "0123456789abcdef"
+
+// prepareCASLayout pre-creates the 256 "content/<xx>" shard directories under
+// dest, so the MkdirAll casImport runs on a shard before writing into it
+// finds the directory already there.
+func prepareCASLayout(dest string) error {
+	for _, a := range casHexDigits {
+		for _, b := range casHexDigits {
+			shard := strings.Join([]string{dest, "content", string(a) + string(b)}, PS)
+			if err := os.MkdirAll(shard, os.ModeDir|0750); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// casContentPath returns the canonical content-addressed location for a file
+// with the given SHA-1 digest and extension, e.g. "content/3a/f9...c2.jpg".
+func casContentPath(dest string, hash string, ext string) string {
+	return strings.Join([]string{dest, "content", hash[0:2], hash[2:] + ext}, PS)
+}
+
+// casDatePath returns the date-index location that mirrors a content path,
+// e.g. "date/2023/07/14/3af9...c2.jpg".
+func casDatePath(dest string, timeTaken time.Time, hash string, ext string) string {
+	return strings.Join(
+		[]string{
+			dest,
+			"date",
+			fmt.Sprintf("%04d", timeTaken.Year()),
+			fmt.Sprintf("%02d", timeTaken.Month()),
+			fmt.Sprintf("%02d", timeTaken.Day()),
+			hash + ext,
+		},
+		PS,
+	)
+}
+
+// linkIntoDateTree creates a reference from the date-index tree back into
+// content/, preferring a hardlink (same filesystem) and falling back to a
+// symlink when that's not possible.
+func linkIntoDateTree(contentPath string, datePath string) error {
+	if err := os.MkdirAll(path.Dir(datePath), os.ModeDir|0750); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(datePath); err == nil {
+		return nil
+	}
+	if err := os.Link(contentPath, datePath); err != nil {
+		return os.Symlink(contentPath, datePath)
+	}
+	return nil
+}
+
+// casImport stores name under the content-addressed layout rooted at dest,
+// deduplicating by SHA-1 digest and mirroring it into the date-index tree
+// when timeTaken is known. It reports whether the file was a true duplicate
+// of something already stored.
+func casImport(name string, dest string, hash string, ext string, timeTaken time.Time) (contentPath string, duplicate bool, err error) {
+	contentPath = casContentPath(dest, hash, ext)
+
+	if _, statErr := os.Stat(contentPath); statErr == nil {
+		existingHash := checksumFile(contentPath)
+		if existingHash == hash {
+			duplicate = true
+		} else {
+			contentPath = disambiguate(contentPath, hash)
+		}
+	}
+
+	if !duplicate {
+		if *flagDryRun == false {
+			if err = os.MkdirAll(path.Dir(contentPath), os.ModeDir|0750); err != nil {
+				return
+			}
+			if *flagMove == true {
+				err = Move(name, contentPath)
+			} else {
+				err = Copy(name, contentPath)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if !timeTaken.IsZero() && *flagDryRun == false {
+		datePath := casDatePath(dest, timeTaken, hash, ext)
+		err = linkIntoDateTree(contentPath, datePath)
+	}
+
+	return
+}
+
+// casRelocateCompanion stores a sidecar (XMP/AAE/Takeout JSON/RAW) under the
+// content-addressed layout next to its primary, mirroring it into the
+// date-index tree under the primary's timeTaken. Sidecars don't carry a
+// trustworthy timestamp of their own, so without this they'd land in
+// content/ with no entry in date/ for anyone browsing that tree to find.
+func casRelocateCompanion(src string, dest string, timeTaken time.Time) Result {
+	hash := checksumFile(src)
+	ext := strings.ToLower(path.Ext(src))
+
+	contentPath, duplicate, err := casImport(src, dest, hash, ext, timeTaken)
+	if err != nil {
+		return Result{Path: src, Outcome: OutcomeError, Err: err}
+	}
+	if duplicate {
+		log.Printf("Duplicate file: %s\n", src)
+		return Result{Path: src, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeDuplicate}
+	}
+	if *flagMove == true {
+		return Result{Path: src, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeMoved}
+	}
+	return Result{Path: src, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeCopied}
+}
+
+// disambiguate appends a short suffix derived from hash to path when two
+// different files would otherwise collide at the same content address.
+func disambiguate(p string, hash string) string {
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	return fmt.Sprintf("%s-%s%s", base, hash[len(hash)-6:], ext)
+}
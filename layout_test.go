@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCasImportStoresAndMirrorsIntoDateTree(t *testing.T) {
+	dest := t.TempDir()
+	src := filepath.Join(t.TempDir(), "IMG_0001.jpg")
+	if err := os.WriteFile(src, []byte("original bytes"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	hash := checksumFile(src)
+	timeTaken := time.Date(2023, time.July, 14, 0, 0, 0, 0, time.UTC)
+
+	contentPath, duplicate, err := casImport(src, dest, hash, ".jpg", timeTaken)
+	if err != nil {
+		t.Fatalf("casImport() error = %v", err)
+	}
+	if duplicate {
+		t.Fatalf("casImport() reported a duplicate on first import")
+	}
+	if _, err := os.Stat(contentPath); err != nil {
+		t.Fatalf("casImport() did not store the file at %q: %v", contentPath, err)
+	}
+
+	datePath := casDatePath(dest, timeTaken, hash, ".jpg")
+	if info, err := os.Lstat(datePath); err != nil {
+		t.Fatalf("casImport() did not mirror into the date tree at %q: %v", datePath, err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		if _, err := os.Stat(datePath); err != nil {
+			t.Fatalf("date tree entry %q is neither a valid hardlink nor readable: %v", datePath, err)
+		}
+	}
+}
+
+func TestCasImportDetectsDuplicate(t *testing.T) {
+	dest := t.TempDir()
+	srcDir := t.TempDir()
+	src := filepath.Join(srcDir, "IMG_0001.jpg")
+	if err := os.WriteFile(src, []byte("same bytes"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	hash := checksumFile(src)
+	timeTaken := time.Date(2023, time.July, 14, 0, 0, 0, 0, time.UTC)
+
+	if _, duplicate, err := casImport(src, dest, hash, ".jpg", timeTaken); err != nil || duplicate {
+		t.Fatalf("casImport() first call: duplicate=%v err=%v, want duplicate=false err=nil", duplicate, err)
+	}
+
+	again := filepath.Join(srcDir, "IMG_0001_copy.jpg")
+	if err := os.WriteFile(again, []byte("same bytes"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	contentPath, duplicate, err := casImport(again, dest, hash, ".jpg", timeTaken)
+	if err != nil {
+		t.Fatalf("casImport() second call error = %v", err)
+	}
+	if !duplicate {
+		t.Fatalf("casImport() of identical content = duplicate false, want true")
+	}
+	if contentPath != casContentPath(dest, hash, ".jpg") {
+		t.Fatalf("casImport() duplicate contentPath = %q, want the canonical content path", contentPath)
+	}
+}
+
+func TestCasImportDisambiguatesHashCollision(t *testing.T) {
+	dest := t.TempDir()
+	srcDir := t.TempDir()
+
+	hash := "aabbccddeeff00112233445566778899aabbccdd"
+
+	first := filepath.Join(srcDir, "a.jpg")
+	if err := os.WriteFile(first, []byte("content A"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	timeTaken := time.Date(2023, time.July, 14, 0, 0, 0, 0, time.UTC)
+
+	contentPathA, duplicateA, err := casImport(first, dest, hash, ".jpg", timeTaken)
+	if err != nil || duplicateA {
+		t.Fatalf("casImport() first file: duplicate=%v err=%v", duplicateA, err)
+	}
+
+	second := filepath.Join(srcDir, "b.jpg")
+	if err := os.WriteFile(second, []byte("content B, totally different"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	contentPathB, duplicateB, err := casImport(second, dest, hash, ".jpg", timeTaken)
+	if err != nil {
+		t.Fatalf("casImport() colliding file error = %v", err)
+	}
+	if duplicateB {
+		t.Fatalf("casImport() reported a colliding-hash, different-content file as a duplicate")
+	}
+	if contentPathB == contentPathA {
+		t.Fatalf("casImport() gave the colliding file the same content path as the original")
+	}
+	if _, err := os.Stat(contentPathB); err != nil {
+		t.Fatalf("disambiguated content path %q was not written: %v", contentPathB, err)
+	}
+}
+
+func TestDisambiguate(t *testing.T) {
+	hash := "3af9c2b1e4d5"
+	got := disambiguate(filepath.Join("content", "3a", "3af9c2b1e4d5.jpg"), hash)
+	want := filepath.Join("content", "3a", "3af9c2b1e4d5-b1e4d5.jpg")
+	if got != want {
+		t.Fatalf("disambiguate() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/gosexy/checksum"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// activeManifest is the manifest for the current run, loaded once in main
+// before the pipeline starts; Import consults and updates it. nil means no
+// manifest is in play (shouldn't happen in normal operation, but every call
+// site checks rather than assume).
+var activeManifest *manifest
+
+// manifestEntry is what Import records for a successfully imported file.
+type manifestEntry struct {
+	Dest string `json:"dest"`
+	Hash string `json:"hash"`
+}
+
+// manifestKey identifies a source file by the things that change if its
+// content does, so a second run over the same SD card can tell "already
+// imported" apart from "new file" without rehashing anything.
+type manifestKey struct {
+	Path  string
+	Size  int64
+	Mtime int64
+}
+
+func (k manifestKey) String() string {
+	return fmt.Sprintf("%s:%d:%d", k.Path, k.Size, k.Mtime)
+}
+
+func statKey(name string) (manifestKey, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return manifestKey{}, err
+	}
+	return manifestKey{Path: name, Size: info.Size(), Mtime: info.ModTime().Unix()}, nil
+}
+
+// manifest is the on-disk .photopy/manifest.json. BySource maps a source
+// file's identity to where it ended up, so Import can skip re-hashing and
+// re-reading EXIF on repeat runs. ByDest maps every destination path to the
+// hash it's supposed to have, which is all -verify needs and all
+// -rebuild-manifest can recover (the original source path/size/mtime are
+// gone once the card has been reformatted or swapped).
+type manifest struct {
+	mu       sync.Mutex
+	path     string
+	BySource map[string]manifestEntry `json:"by_source"`
+	ByDest   map[string]string        `json:"by_dest"`
+}
+
+func manifestPath(dest string) string {
+	return strings.Join([]string{dest, ".photopy", "manifest.json"}, PS)
+}
+
+// loadManifest reads dest's manifest, returning an empty one if it doesn't
+// exist yet.
+func loadManifest(dest string) (*manifest, error) {
+	m := &manifest{
+		path:     manifestPath(dest),
+		BySource: make(map[string]manifestEntry),
+		ByDest:   make(map[string]string),
+	}
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *manifest) lookup(key manifestKey) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.BySource[key.String()]
+	return entry, ok
+}
+
+// record stores a newly-imported file's entry and persists the manifest
+// transactionally, so a crash partway through a run loses at most the files
+// currently in flight.
+func (m *manifest) record(key manifestKey, entry manifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.BySource[key.String()] = entry
+	m.ByDest[entry.Dest] = entry.Hash
+	return m.save()
+}
+
+func (m *manifest) save() error {
+	if err := os.MkdirAll(path.Dir(m.path), os.ModeDir|0750); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// rebuildManifest regenerates dest's manifest by rehashing every file under
+// dest. It can only repopulate ByDest: the original source identity isn't
+// recoverable from the destination tree alone, so a rebuilt manifest will
+// re-hash (but not re-copy, since the destination path still exists) source
+// files on the next normal run.
+func rebuildManifest(dest string) (*manifest, error) {
+	m := &manifest{
+		path:     manifestPath(dest),
+		BySource: make(map[string]manifestEntry),
+		ByDest:   make(map[string]string),
+	}
+
+	manifestDir := strings.Join([]string{dest, ".photopy"}, PS)
+
+	err := filepath.Walk(dest, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == manifestDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		m.ByDest[p] = checksum.File(p, crypto.SHA1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.save(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// verifyManifest re-hashes every file the manifest knows about and reports
+// every path whose destination is missing or no longer matches.
+func verifyManifest(m *manifest) []string {
+	failures := make([]string, 0)
+	for destPath, wantHash := range m.ByDest {
+		if _, err := os.Stat(destPath); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", destPath, err))
+			continue
+		}
+		if gotHash := checksum.File(destPath, crypto.SHA1); gotHash != wantHash {
+			failures = append(failures, fmt.Sprintf("%s: hash mismatch (want %s, got %s)", destPath, wantHash, gotHash))
+		}
+	}
+	return failures
+}
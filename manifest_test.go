@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestRecordLoadRoundTrip(t *testing.T) {
+	dest := t.TempDir()
+
+	m, err := loadManifest(dest)
+	if err != nil {
+		t.Fatalf("loadManifest() error = %v", err)
+	}
+
+	key := manifestKey{Path: filepath.Join(dest, "src", "IMG_0001.JPG"), Size: 1024, Mtime: 1689312000}
+	entry := manifestEntry{Dest: filepath.Join(dest, "2023", "07", "14", "IMG_0001.JPG"), Hash: "deadbeef"}
+
+	if err := m.record(key, entry); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+
+	if _, err := os.Stat(manifestPath(dest)); err != nil {
+		t.Fatalf("manifest was not persisted: %v", err)
+	}
+
+	reloaded, err := loadManifest(dest)
+	if err != nil {
+		t.Fatalf("loadManifest() after record error = %v", err)
+	}
+
+	got, ok := reloaded.lookup(key)
+	if !ok {
+		t.Fatalf("lookup() found nothing for a key that was just recorded")
+	}
+	if got != entry {
+		t.Fatalf("lookup() = %+v, want %+v", got, entry)
+	}
+
+	if reloaded.ByDest[entry.Dest] != entry.Hash {
+		t.Fatalf("ByDest[%q] = %q, want %q", entry.Dest, reloaded.ByDest[entry.Dest], entry.Hash)
+	}
+}
+
+func TestLoadManifestMissingIsEmpty(t *testing.T) {
+	m, err := loadManifest(t.TempDir())
+	if err != nil {
+		t.Fatalf("loadManifest() error = %v", err)
+	}
+	if len(m.BySource) != 0 || len(m.ByDest) != 0 {
+		t.Fatalf("loadManifest() of a fresh dir = %+v, want empty maps", m)
+	}
+}
+
+func TestVerifyManifestReportsMissingDest(t *testing.T) {
+	dest := t.TempDir()
+	present := filepath.Join(dest, "present.jpg")
+	if err := os.WriteFile(present, []byte("hello"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	m := &manifest{
+		path: manifestPath(dest),
+		ByDest: map[string]string{
+			present:                            checksumFile(present),
+			filepath.Join(dest, "missing.jpg"): "0000000000000000000000000000000000000000",
+		},
+		BySource: make(map[string]manifestEntry),
+	}
+
+	failures := verifyManifest(m)
+	if len(failures) != 1 {
+		t.Fatalf("verifyManifest() = %v, want exactly one failure", failures)
+	}
+}
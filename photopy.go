@@ -24,17 +24,15 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto"
 	"flag"
 	"fmt"
 	"github.com/gosexy/checksum"
-	"github.com/gosexy/exif"
 	"github.com/gosexy/to"
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"regexp"
 	"runtime"
@@ -45,14 +43,9 @@ import (
 
 const PS = string(os.PathSeparator)
 
-var pcount = 0
-
-var ok chan int
-
-var statsCopied int
-var statsMoved int
-var statsSkipped int
-var statsNotExif int
+// formatLayout is *flagFormat translated into a Go time.Format layout once
+// at startup; see compileFormat.
+var formatLayout string
 
 var flagFrom = flag.String("from", "", "Media source directory.")
 var flagDest = flag.String("to", "", "Media destination directory.")
@@ -61,50 +54,14 @@ var flagDryRun = flag.Bool("dry-run", false, "Prints what would be done without
 var flagMaxProcs = flag.Int("max-procs", runtime.NumCPU(), "The maximum number of tasks running at the same time.")
 var flagExifTool = flag.Bool("exiftool", false, "Use exiftool instead of libexif (slower. requires exiftool to be installed).")
 var flagTryExifTool = flag.Bool("try-exiftool", false, "Fallback to exiftool if libexif fails (requires exiftool to be installed).")
-
-func getExifData(file string) (map[string]string, error) {
-	var err error
-
-	if *flagExifTool == false || *flagTryExifTool == true {
-
-		ex := exif.New()
-		err = ex.Open(file)
-
-		if err == nil {
-			return ex.Tags, nil
-		}
-
-	}
-
-	if *flagExifTool == true || *flagTryExifTool == true {
-
-		cmd := exec.Command("exiftool", file)
-
-		var out bytes.Buffer
-		cmd.Stdout = &out
-
-		err := cmd.Run()
-
-		if err != nil {
-			return nil, err
-		}
-
-		tags := make(map[string]string)
-
-		data := strings.Trim(out.String(), " \r\n")
-		lines := strings.Split(data, "\n")
-
-		for _, line := range lines {
-			key := strings.Trim(line[0:32], " ")
-			value := strings.Trim(line[33:], " ")
-			tags[key] = value
-		}
-
-		return tags, nil
-	}
-
-	return nil, fmt.Errorf("Could not read EXIF data.")
-}
+var flagLayout = flag.String("layout", "legacy", "Destination layout: \"legacy\" (date-based tree) or \"cas\" (content-addressed store with a date-index mirror).")
+var flagExtractors = flag.String("extractors", "", "Comma-separated fallback chain of metadata extractors to try, in order: libexif, exiftool, ffprobe, id3. Defaults to libexif (or exiftool with -exiftool), followed by ffprobe and id3.")
+var flagFormat = flag.String("format", "%Y/%m-%B/%d-%A/%H%M%S-%h%e", "strftime-style destination path template for the legacy layout. Tokens: %Y %y %m %B %d %A %H %M %S (from the EXIF timestamp), %h (hash prefix), %e (lowercase extension), %c (camera model), %o (original basename), %L (reverse-geocoded locality), %C (reverse-geocoded country).")
+var flagVerify = flag.Bool("verify", false, "Walk the destination manifest (.photopy/manifest.json) and verify each file still matches its recorded hash, then exit.")
+var flagRebuildManifest = flag.Bool("rebuild-manifest", false, "Regenerate the destination manifest by rehashing the destination tree, then exit.")
+var flagGeocoder = flag.String("geocoder", "offline", "Reverse geocoder backing the %L/%C format tokens: \"offline\" (a downloaded cities1000.txt gazetteer, see -geocode-cities) or \"nominatim\" (online, cached on disk).")
+var flagGeocodeCities = flag.String("geocode-cities", "", "Path to a cities1000.txt gazetteer (http://download.geonames.org/export/dump/cities1000.zip) used by -geocoder=offline.")
+var flagGeocodeCacheDir = flag.String("geocode-cache-dir", "", "Directory for caching -geocoder=nominatim responses (defaults to <to>/.photopy/geocache).")
 
 func verifyDirectory(name string) error {
 	stat, err := os.Stat(name)
@@ -213,185 +170,220 @@ func pick(values ...string) string {
 	return ""
 }
 
-func Import(name string, dest string) {
+// Import parses name's metadata and copies or moves it into dest, returning
+// the outcome instead of bumping shared counters; the only shared state it
+// touches is activeManifest, which locks internally, so it's safe to call
+// from any number of concurrent pipeline workers. fallbackTaken, if non-empty, is an
+// "YYYY:MM:DD HH:MM:SS" timestamp used when name carries no EXIF/container
+// timestamp of its own (e.g. taken from a Google-Takeout JSON sidecar).
+func Import(ctx context.Context, name string, dest string, fallbackTaken string) Result {
 
-	defer func() {
-		ok <- 1
-	}()
+	if err := ctx.Err(); err != nil {
+		return Result{Path: name, Outcome: OutcomeError, Err: err}
+	}
 
-	re, _ := regexp.Compile(`(\d{4}):(\d{2}):(\d{2}) (\d{2}):(\d{2}):(\d{2})`)
+	key, keyErr := statKey(name)
+	if keyErr == nil && activeManifest != nil {
+		if entry, ok := activeManifest.lookup(key); ok {
+			return Result{Path: name, Dest: entry.Dest, Outcome: OutcomeSkipped}
+		}
+	}
 
-	tags, err := getExifData(name)
+	re, _ := regexp.Compile(`(\d{4}):(\d{2}):(\d{2}) (\d{2}):(\d{2}):(\d{2})`)
 
-	if err == nil {
+	tags, err := getMetadata(name)
+	if err != nil {
+		return Result{Path: name, Outcome: OutcomeNoEXIF}
+	}
 
-		rename := ""
+	rename := ""
+	var fileHash string
+	var timeTaken time.Time
 
-		switch tags["File Type"] {
+	switch tags["File Type"] {
 
-		case "MP3":
+	case "MP3", "FLAC", "WAV":
 
-			hash := checksum.File(name, crypto.SHA1)
+		hash := checksum.File(name, crypto.SHA1)
+		fileHash = hash
 
-			rename = strings.Join(
-				[]string{
-					dest,
-					normalize(pick(tags["Artist"], "Unknown Artist")),
-					normalize(pick(tags["Album"], "Unknown Album")),
-					fmt.Sprintf("%s%s", normalize(tags["Track"], fmt.Sprintf("%s-%s", pick(tags["Title"], "Unknown Title"), hash[0:4])), pick(strings.ToLower(path.Ext(name)), ".mp3")),
-				},
-				PS,
-			)
+		rename = strings.Join(
+			[]string{
+				dest,
+				normalize(pick(tags["Artist"], "Unknown Artist")),
+				normalize(pick(tags["Album"], "Unknown Album")),
+				fmt.Sprintf("%s%s", normalize(tags["Track"], fmt.Sprintf("%s-%s", pick(tags["Title"], "Unknown Title"), hash[0:4])), pick(strings.ToLower(path.Ext(name)), ".mp3")),
+			},
+			PS,
+		)
 
-		default:
-			var taken string
+	default:
+		var taken string
 
-			dateTimeFields := []string{
-				"Date and Time (Original)",
-				"Date/Time Original",
-				"Media Create Date",
-				"Track Create Date",
-				"Create Date",
-			}
+		dateTimeFields := []string{
+			"Date and Time (Original)",
+			"Date/Time Original",
+			"Media Create Date",
+			"Track Create Date",
+			"Create Date",
+		}
 
-			for _, field := range dateTimeFields {
-				if tags[field] != "" {
-					taken = tags[field]
-					break
-				}
+		for _, field := range dateTimeFields {
+			if tags[field] != "" {
+				taken = tags[field]
+				break
 			}
+		}
 
-			if taken == "" {
-				statsNotExif++
-				return
-			}
+		taken = pick(taken, fallbackTaken)
 
-			all := re.FindAllStringSubmatch(taken, -1)
-
-			timeTaken := time.Date(
-				to.Int(all[0][1]),
-				time.Month(to.Int(all[0][2])),
-				to.Int(all[0][3]),
-				to.Int(all[0][4]),
-				to.Int(all[0][5]),
-				to.Int(all[0][6]),
-				0,
-				time.UTC,
-			)
-
-			hash := checksum.File(name, crypto.SHA1)
-
-			rename = strings.Join(
-				[]string{
-					dest,
-					to.String(timeTaken.Year()),
-					fmt.Sprintf("%02d-%s", timeTaken.Month(), timeTaken.Month()),
-					fmt.Sprintf("%02d-%s", timeTaken.Day(), timeTaken.Weekday()),
-					fmt.Sprintf("%02d%02d%02d-%s%s", timeTaken.Hour(), timeTaken.Minute(), timeTaken.Second(), strings.ToUpper(hash[0:4]), strings.ToLower(path.Ext(name))),
-				},
-				PS,
-			)
+		if taken == "" {
+			return Result{Path: name, Outcome: OutcomeNoEXIF}
 		}
 
-		if rename != "" {
+		all := re.FindAllStringSubmatch(taken, -1)
 
-			_, err := os.Stat(rename)
+		timeTaken = time.Date(
+			to.Int(all[0][1]),
+			time.Month(to.Int(all[0][2])),
+			to.Int(all[0][3]),
+			to.Int(all[0][4]),
+			to.Int(all[0][5]),
+			to.Int(all[0][6]),
+			0,
+			time.UTC,
+		)
 
+		hash := checksum.File(name, crypto.SHA1)
+		fileHash = hash
+
+		if *flagLayout == "cas" {
+			contentPath, duplicate, err := casImport(name, dest, hash, strings.ToLower(path.Ext(name)), timeTaken)
 			if err != nil {
+				return Result{Path: name, Outcome: OutcomeError, Err: err}
+			}
+			if duplicate {
+				log.Printf("Duplicate file: %s\n", name)
+				return Result{Path: name, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeDuplicate}
+			}
+			if *flagDryRun == false && keyErr == nil && activeManifest != nil {
+				activeManifest.record(key, manifestEntry{Dest: contentPath, Hash: hash})
+			}
+			if *flagMove == true {
+				return Result{Path: name, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeMoved}
+			}
+			return Result{Path: name, Dest: contentPath, TimeTaken: timeTaken, Outcome: OutcomeCopied}
+		}
 
-				if *flagDryRun == false {
-					err = os.MkdirAll(path.Dir(rename), os.ModeDir|0750)
-					if err != nil {
-						panic(err)
-					}
-				}
-				err = nil
-				if *flagMove == true {
-					log.Printf("Moving file: %s -> %s\n", name, rename)
-					if *flagDryRun == false {
-						err = Move(name, rename)
-						statsMoved++
-					}
-				} else {
-					log.Printf("Copying file: %s -> %s\n", name, rename)
-					if *flagDryRun == false {
-						err = Copy(name, rename)
-						statsCopied++
-					}
-				}
-				if err != nil {
-					panic(err)
-				}
+		ext := path.Ext(name)
 
-			} else {
-				log.Printf("Skipping file: %s\n", rename)
-				statsSkipped++
+		var locality, country string
+		if activeGeocoder != nil {
+			lat, latErr := parseGPSCoordinate(tags["GPS Latitude"])
+			lon, lonErr := parseGPSCoordinate(tags["GPS Longitude"])
+			if latErr == nil && lonErr == nil {
+				locality, country, _ = activeGeocoder.Reverse(lat, lon)
 			}
-
-		} else {
-			statsNotExif++
 		}
 
-	} else {
-		statsNotExif++
+		rename = strings.Join(
+			[]string{
+				dest,
+				formatPath(formatLayout, timeTaken, formatContext{
+					Hash:         hash[0:4],
+					Ext:          ext,
+					CameraModel:  tags["Model"],
+					OriginalBase: strings.TrimSuffix(path.Base(name), ext),
+					Locality:     locality,
+					Country:      country,
+				}),
+			},
+			PS,
+		)
 	}
 
-}
-
-func Scandir(dirname string, dest string) error {
-
-	var err error
-
-	stat, err := os.Stat(dirname)
-
-	if err != nil {
-		return err
+	if rename == "" {
+		return Result{Path: name, Outcome: OutcomeNoEXIF}
 	}
 
-	if stat.IsDir() == false {
-		return fmt.Errorf("Not a directory.")
+	if _, err := os.Stat(rename); err == nil {
+		log.Printf("Skipping file: %s\n", rename)
+		return Result{Path: name, Dest: rename, TimeTaken: timeTaken, Outcome: OutcomeSkipped}
 	}
 
-	dh, err := os.Open(dirname)
+	if *flagDryRun == false {
+		if err := os.MkdirAll(path.Dir(rename), os.ModeDir|0750); err != nil {
+			return Result{Path: name, Outcome: OutcomeError, Err: err}
+		}
+	}
 
-	if err != nil {
-		return err
+	if *flagMove == true {
+		log.Printf("Moving file: %s -> %s\n", name, rename)
+		if *flagDryRun == false {
+			if err := Move(name, rename); err != nil {
+				return Result{Path: name, Outcome: OutcomeError, Err: err}
+			}
+		}
+		if *flagDryRun == false && keyErr == nil && activeManifest != nil {
+			activeManifest.record(key, manifestEntry{Dest: rename, Hash: fileHash})
+		}
+		return Result{Path: name, Dest: rename, TimeTaken: timeTaken, Outcome: OutcomeMoved}
 	}
 
-	defer dh.Close()
+	log.Printf("Copying file: %s -> %s\n", name, rename)
+	if *flagDryRun == false {
+		if err := Copy(name, rename); err != nil {
+			return Result{Path: name, Outcome: OutcomeError, Err: err}
+		}
+	}
+	if *flagDryRun == false && keyErr == nil && activeManifest != nil {
+		activeManifest.record(key, manifestEntry{Dest: rename, Hash: fileHash})
+	}
+	return Result{Path: name, Dest: rename, TimeTaken: timeTaken, Outcome: OutcomeCopied}
+}
 
-	files, err := dh.Readdir(-1)
+func main() {
 
-	if err != nil {
-		return err
-	}
+	flag.Parse()
 
-	for _, file := range files {
+	formatLayout = compileFormat(*flagFormat)
 
-		name := dirname + PS + file.Name()
+	if *flagVerify == true || *flagRebuildManifest == true {
+		if *flagDest == "" {
+			log.Println("-verify and -rebuild-manifest require -to.")
+			return
+		}
+		if err := verifyDirectory(*flagDest); err != nil {
+			log.Println(err.Error())
+			return
+		}
 
-		if file.IsDir() == true {
-			Scandir(name, dest)
-		} else {
-			if pcount >= *flagMaxProcs {
-				// Waiting for one task to finish
-				<-ok
-				pcount--
+		if *flagRebuildManifest == true {
+			m, err := rebuildManifest(*flagDest)
+			if err != nil {
+				log.Println(err.Error())
+				return
 			}
-			go Import(name, dest)
-			// Task count
-			pcount++
+			fmt.Printf("Rebuilt manifest with %d entries.\n", len(m.ByDest))
+			return
 		}
 
+		m, err := loadManifest(*flagDest)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		failures := verifyManifest(m)
+		for _, failure := range failures {
+			log.Println(failure)
+		}
+		fmt.Printf("Verified %d entries, %d failed.\n", len(m.ByDest), len(failures))
+		if len(failures) > 0 {
+			os.Exit(1)
+		}
+		return
 	}
 
-	return nil
-}
-
-func main() {
-
-	flag.Parse()
-
 	if *flagFrom == "" || *flagDest == "" {
 		fmt.Printf("Photopy, by xiam <xiam@menteslibres.org>, Mexico City.\n\n")
 		fmt.Printf("A command line tool for importing photos.\n\n")
@@ -401,8 +393,6 @@ func main() {
 	} else {
 		var err error
 
-		ok = make(chan int, *flagMaxProcs)
-
 		err = verifyDirectory(*flagFrom)
 		if err != nil {
 			log.Println(err.Error())
@@ -415,13 +405,68 @@ func main() {
 			return
 		}
 
-		Scandir(*flagFrom, *flagDest)
+		if *flagLayout == "cas" && *flagDryRun == false {
+			err = prepareCASLayout(*flagDest)
+			if err != nil {
+				log.Println(err.Error())
+				return
+			}
+		}
 
-		// Waiting for all tasks to finish
-		for i := 0; i < pcount; i++ {
-			<-ok
+		activeManifest, err = loadManifest(*flagDest)
+		if err != nil {
+			log.Println(err.Error())
+			return
 		}
 
-		fmt.Printf("Copied: %d, Moved: %d, Skipped: %d, Without EXIF data: %d\n", statsCopied, statsMoved, statsSkipped, statsNotExif)
+		if strings.Contains(*flagFormat, "%L") || strings.Contains(*flagFormat, "%C") {
+			switch *flagGeocoder {
+			case "nominatim":
+				cacheDir := pick(*flagGeocodeCacheDir, strings.Join([]string{*flagDest, ".photopy", "geocache"}, PS))
+				activeGeocoder = NewNominatimGeocoder(cacheDir)
+			default:
+				if *flagGeocodeCities == "" {
+					log.Println("the %L/%C format tokens need -geocode-cities (a cities1000.txt gazetteer) when -geocoder=offline.")
+					return
+				}
+				offline, err := NewOfflineGeocoder(*flagGeocodeCities)
+				if err != nil {
+					log.Println(err.Error())
+					return
+				}
+				activeGeocoder = offline
+			}
+		}
+
+		results, err := runPipeline(context.Background(), *flagFrom, *flagDest)
+		if err != nil {
+			log.Println(err.Error())
+		}
+
+		var statsCopied, statsMoved, statsSkipped, statsDuplicate, statsNotExif, statsErrors int
+
+		for _, r := range results {
+			switch r.Outcome {
+			case OutcomeCopied:
+				statsCopied++
+			case OutcomeMoved:
+				statsMoved++
+			case OutcomeSkipped:
+				statsSkipped++
+			case OutcomeDuplicate:
+				statsDuplicate++
+			case OutcomeNoEXIF:
+				statsNotExif++
+			case OutcomeError:
+				statsErrors++
+				log.Printf("Error importing %s: %s\n", r.Path, r.Err)
+			}
+		}
+
+		fmt.Printf("Copied: %d, Moved: %d, Skipped: %d, Duplicate: %d, Without EXIF data: %d, Errors: %d\n", statsCopied, statsMoved, statsSkipped, statsDuplicate, statsNotExif, statsErrors)
+
+		if statsErrors > 0 {
+			os.Exit(1)
+		}
 	}
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outcome is what happened to a single file as it passed through the
+// pipeline.
+type Outcome int
+
+const (
+	OutcomeCopied Outcome = iota
+	OutcomeMoved
+	OutcomeSkipped
+	OutcomeDuplicate
+	OutcomeNoEXIF
+	OutcomeError
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeCopied:
+		return "Copied"
+	case OutcomeMoved:
+		return "Moved"
+	case OutcomeSkipped:
+		return "Skipped"
+	case OutcomeDuplicate:
+		return "Duplicate"
+	case OutcomeNoEXIF:
+		return "NoEXIF"
+	default:
+		return "Error"
+	}
+}
+
+// Result is what a worker reports back for a single file.
+type Result struct {
+	Path      string
+	Dest      string    // destination path Import chose, if any
+	TimeTaken time.Time // timeTaken Import resolved, if any; zero value if none (e.g. audio)
+	Outcome   Outcome
+	Err       error
+}
+
+// walkAllFiles collects every regular file under root. It's not streamed,
+// because grouping sidecars by stem needs to see a directory's entries
+// together before any of them are handed to a worker.
+func walkAllFiles(root string) ([]string, error) {
+	files := make([]string, 0)
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// runPipeline walks from, groups what it finds into stem-based fileGroups
+// (so sidecars travel with their primary), and fans those groups out across
+// *flagMaxProcs workers that call ImportGroup against dest. It cancels the
+// whole pipeline on SIGINT and returns every per-file Result plus a non-nil
+// error if the walk itself failed.
+func runPipeline(ctx context.Context, from string, dest string) ([]Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	files, walkErr := walkAllFiles(from)
+	groups := groupByStem(files)
+
+	work := make(chan *fileGroup, *flagMaxProcs)
+	results := make(chan Result, *flagMaxProcs)
+
+	go func() {
+		defer close(work)
+		for _, g := range groups {
+			select {
+			case work <- g:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < *flagMaxProcs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for g := range work {
+				for _, r := range ImportGroup(ctx, g, dest) {
+					results <- r
+				}
+			}
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	all := make([]Result, 0)
+	for r := range results {
+		all = append(all, r)
+	}
+
+	if walkErr != nil {
+		return all, walkErr
+	}
+	return all, nil
+}
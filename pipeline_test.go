@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestOutcomeString(t *testing.T) {
+	cases := map[Outcome]string{
+		OutcomeCopied:    "Copied",
+		OutcomeMoved:     "Moved",
+		OutcomeSkipped:   "Skipped",
+		OutcomeDuplicate: "Duplicate",
+		OutcomeNoEXIF:    "NoEXIF",
+		OutcomeError:     "Error",
+		Outcome(99):      "Error",
+	}
+	for outcome, want := range cases {
+		if got := outcome.String(); got != want {
+			t.Fatalf("Outcome(%d).String() = %q, want %q", outcome, got, want)
+		}
+	}
+}
+
+func TestWalkAllFiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0750); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("sub", "b.txt")} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte("x"), 0640); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	files, err := walkAllFiles(root)
+	if err != nil {
+		t.Fatalf("walkAllFiles() error = %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("walkAllFiles() = %v, want 2 entries", files)
+	}
+}
+
+func TestRunPipelineAggregatesResultsForEveryFile(t *testing.T) {
+	from := t.TempDir()
+	dest := t.TempDir()
+
+	names := []string{"IMG_0001.txt", "IMG_0002.txt", "IMG_0003.txt"}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(from, name), []byte("not a real photo"), 0640); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+
+	results, err := runPipeline(context.Background(), from, dest)
+	if err != nil {
+		t.Fatalf("runPipeline() error = %v", err)
+	}
+	if len(results) != len(names) {
+		t.Fatalf("runPipeline() returned %d results, want %d", len(results), len(names))
+	}
+
+	got := make([]string, len(results))
+	for i, r := range results {
+		if r.Outcome != OutcomeNoEXIF {
+			t.Fatalf("runPipeline() result for %q = %v, want NoEXIF (no extractor handles .txt)", r.Path, r.Outcome)
+		}
+		got[i] = filepath.Base(r.Path)
+	}
+	sort.Strings(got)
+
+	want := append([]string(nil), names...)
+	sort.Strings(want)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("runPipeline() covered files %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRunPipelineHonorsCancellation(t *testing.T) {
+	from := t.TempDir()
+	dest := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(from, "IMG_0001.txt"), []byte("x"), 0640); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := runPipeline(ctx, from, dest)
+	if err != nil {
+		t.Fatalf("runPipeline() error = %v", err)
+	}
+	// A pre-cancelled context may drop in-flight groups entirely, so we can
+	// only assert that whatever did come back reports the cancellation
+	// instead of silently proceeding as if nothing happened.
+	for _, r := range results {
+		if r.Outcome != OutcomeError {
+			t.Fatalf("runPipeline() result for %q = %v, want Error after cancellation", r.Path, r.Outcome)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sidecarPriority ranks extensions so ImportGroup can pick one "primary" per
+// stem group to derive the destination name from; JPEG/video/audio masters
+// outrank their RAW and sidecar companions.
+var sidecarPriority = []string{
+	".jpg", ".jpeg", ".png", ".tif", ".tiff",
+	".mp4", ".mov", ".mkv", ".avi", ".flv",
+	".mp3", ".flac", ".wav",
+	".cr2", ".nef", ".arw", ".dng", ".raf",
+}
+
+// fileGroup is every file sharing a directory and basename-without-extension,
+// e.g. IMG_1234.CR2 / IMG_1234.JPG / IMG_1234.xmp.
+type fileGroup struct {
+	Members []string
+}
+
+// stemFor returns the directory+stem groupByStem clusters p under. Google
+// Takeout names its JSON sidecar after the *whole* original filename (e.g.
+// "20230714_153012.jpg.json" alongside "20230714_153012.jpg"), not after the
+// stem alone, so a ".json" file needs its own extension stripped as well as
+// the original's before it lines up with the primary's stem.
+func stemFor(p string) string {
+	if strings.ToLower(path.Ext(p)) == ".json" {
+		p = strings.TrimSuffix(p, path.Ext(p))
+	}
+	return strings.TrimSuffix(p, path.Ext(p))
+}
+
+// groupByStem clusters file paths that share a directory+stem into
+// fileGroups, preserving the order stems were first seen in.
+func groupByStem(files []string) []*fileGroup {
+	index := make(map[string]*fileGroup)
+	order := make([]string, 0)
+
+	for _, p := range files {
+		stem := stemFor(p)
+		g, ok := index[stem]
+		if !ok {
+			g = &fileGroup{}
+			index[stem] = g
+			order = append(order, stem)
+		}
+		g.Members = append(g.Members, p)
+	}
+
+	groups := make([]*fileGroup, len(order))
+	for i, stem := range order {
+		groups[i] = index[stem]
+	}
+	return groups
+}
+
+// primary picks the group member ImportGroup should derive the destination
+// name from, by sidecarPriority; ties fall back to the first member seen.
+func (g *fileGroup) primary() string {
+	for _, ext := range sidecarPriority {
+		for _, member := range g.Members {
+			if strings.ToLower(path.Ext(member)) == ext {
+				return member
+			}
+		}
+	}
+	return g.Members[0]
+}
+
+// companions returns every group member other than primary.
+func (g *fileGroup) companions(primary string) []string {
+	out := make([]string, 0, len(g.Members)-1)
+	for _, m := range g.Members {
+		if m != primary {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// takeoutTimestamp reads photoTakenTime.timestamp out of a Google-Takeout
+// JSON sidecar and renders it in the "YYYY:MM:DD HH:MM:SS" shape Import's
+// EXIF regex expects, for use as a fallback when the primary has no
+// timestamp of its own.
+func takeoutTimestamp(jsonPath string) (string, error) {
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return "", err
+	}
+
+	var sidecar struct {
+		PhotoTakenTime struct {
+			Timestamp string `json:"timestamp"`
+		} `json:"photoTakenTime"`
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return "", err
+	}
+	if sidecar.PhotoTakenTime.Timestamp == "" {
+		return "", fmt.Errorf("%s: no photoTakenTime.timestamp", jsonPath)
+	}
+
+	seconds, err := strconv.ParseInt(sidecar.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil {
+		return "", err
+	}
+	return time.Unix(seconds, 0).UTC().Format("2006:01:02 15:04:05"), nil
+}
+
+// companionDest derives a sidecar's destination path by swapping in its own
+// extension onto the primary's (already-renamed) destination basename.
+func companionDest(primaryDest string, companion string) string {
+	primaryExt := path.Ext(primaryDest)
+	base := strings.TrimSuffix(primaryDest, primaryExt)
+	return base + path.Ext(companion)
+}
+
+// relocate copies or moves src to dst, following the same -move/-dry-run
+// conventions Import uses, and reports the outcome as a Result for src.
+func relocate(src string, dst string) Result {
+	if _, err := os.Stat(dst); err == nil {
+		log.Printf("Skipping file: %s\n", dst)
+		return Result{Path: src, Dest: dst, Outcome: OutcomeSkipped}
+	}
+
+	if *flagDryRun == false {
+		if err := os.MkdirAll(path.Dir(dst), os.ModeDir|0750); err != nil {
+			return Result{Path: src, Outcome: OutcomeError, Err: err}
+		}
+	}
+
+	if *flagMove == true {
+		log.Printf("Moving file: %s -> %s\n", src, dst)
+		if *flagDryRun == false {
+			if err := Move(src, dst); err != nil {
+				return Result{Path: src, Outcome: OutcomeError, Err: err}
+			}
+		}
+		return Result{Path: src, Dest: dst, Outcome: OutcomeMoved}
+	}
+
+	log.Printf("Copying file: %s -> %s\n", src, dst)
+	if *flagDryRun == false {
+		if err := Copy(src, dst); err != nil {
+			return Result{Path: src, Outcome: OutcomeError, Err: err}
+		}
+	}
+	return Result{Path: src, Dest: dst, Outcome: OutcomeCopied}
+}
+
+// ImportGroup imports a stem group as a unit: the primary member drives
+// metadata lookup and destination naming (falling back to a Google-Takeout
+// JSON sidecar's timestamp when the primary has none of its own), and every
+// other member is relocated alongside it under the primary's new basename
+// (in the cas layout, each one is stored at its own content address and
+// linked into the date tree under the primary's timeTaken instead).
+func ImportGroup(ctx context.Context, group *fileGroup, dest string) []Result {
+	if len(group.Members) == 1 {
+		return []Result{Import(ctx, group.Members[0], dest, "")}
+	}
+
+	primary := group.primary()
+	companions := group.companions(primary)
+
+	fallbackTaken := ""
+	for _, c := range companions {
+		if strings.ToLower(path.Ext(c)) == ".json" {
+			if ts, err := takeoutTimestamp(c); err == nil {
+				fallbackTaken = ts
+			}
+		}
+	}
+
+	primaryResult := Import(ctx, primary, dest, fallbackTaken)
+	results := []Result{primaryResult}
+
+	if primaryResult.Dest == "" {
+		for _, c := range companions {
+			results = append(results, Result{Path: c, Outcome: primaryResult.Outcome, Err: primaryResult.Err})
+		}
+		return results
+	}
+
+	for _, c := range companions {
+		if *flagLayout == "cas" {
+			results = append(results, casRelocateCompanion(c, dest, primaryResult.TimeTaken))
+			continue
+		}
+		results = append(results, relocate(c, companionDest(primaryResult.Dest, c)))
+	}
+
+	return results
+}
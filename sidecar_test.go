@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupByStemTakeoutJSON(t *testing.T) {
+	photo := filepath.Join("a", "20230714_153012.jpg")
+	sidecar := filepath.Join("a", "20230714_153012.jpg.json")
+
+	groups := groupByStem([]string{photo, sidecar})
+	if len(groups) != 1 {
+		t.Fatalf("groupByStem() = %d groups, want 1", len(groups))
+	}
+	if len(groups[0].Members) != 2 {
+		t.Fatalf("groupByStem() group has %d members, want 2", len(groups[0].Members))
+	}
+}
+
+func TestGroupByStemXMPAndRAW(t *testing.T) {
+	jpg := filepath.Join("a", "IMG_1234.JPG")
+	raw := filepath.Join("a", "IMG_1234.CR2")
+	xmp := filepath.Join("a", "IMG_1234.xmp")
+
+	groups := groupByStem([]string{jpg, raw, xmp})
+	if len(groups) != 1 {
+		t.Fatalf("groupByStem() = %d groups, want 1", len(groups))
+	}
+	if len(groups[0].Members) != 3 {
+		t.Fatalf("groupByStem() group has %d members, want 3", len(groups[0].Members))
+	}
+}
+
+func TestGroupByStemDistinctStemsDontMerge(t *testing.T) {
+	groups := groupByStem([]string{
+		filepath.Join("a", "IMG_1234.JPG"),
+		filepath.Join("a", "IMG_5678.JPG"),
+	})
+	if len(groups) != 2 {
+		t.Fatalf("groupByStem() = %d groups, want 2", len(groups))
+	}
+}
+
+func TestFileGroupPrimaryPrefersJPEGOverRAWAndSidecars(t *testing.T) {
+	g := &fileGroup{Members: []string{
+		filepath.Join("a", "IMG_1234.CR2"),
+		filepath.Join("a", "IMG_1234.xmp"),
+		filepath.Join("a", "IMG_1234.JPG"),
+	}}
+
+	primary := g.primary()
+	if primary != filepath.Join("a", "IMG_1234.JPG") {
+		t.Fatalf("primary() = %q, want the JPEG", primary)
+	}
+
+	companions := g.companions(primary)
+	if len(companions) != 2 {
+		t.Fatalf("companions() = %d, want 2", len(companions))
+	}
+	for _, c := range companions {
+		if c == primary {
+			t.Fatalf("companions() included the primary: %q", c)
+		}
+	}
+}
+
+func TestFileGroupPrimaryFallsBackToFirstMember(t *testing.T) {
+	g := &fileGroup{Members: []string{
+		filepath.Join("a", "IMG_1234.xmp"),
+		filepath.Join("a", "IMG_1234.aae"),
+	}}
+
+	if primary := g.primary(); primary != g.Members[0] {
+		t.Fatalf("primary() = %q, want first member %q", primary, g.Members[0])
+	}
+}
+
+func TestCompanionDest(t *testing.T) {
+	primaryDest := filepath.Join("dest", "2023", "07", "IMG_1234.JPG")
+	companion := filepath.Join("a", "IMG_1234.xmp")
+
+	got := companionDest(primaryDest, companion)
+	want := filepath.Join("dest", "2023", "07", "IMG_1234.xmp")
+	if got != want {
+		t.Fatalf("companionDest() = %q, want %q", got, want)
+	}
+}